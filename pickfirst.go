@@ -19,17 +19,60 @@
 package grpc
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"math/rand"
+	"sync"
+	"time"
 
 	"google.golang.org/grpc/balancer"
 	"google.golang.org/grpc/connectivity"
 	"google.golang.org/grpc/grpclog"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
 )
 
+// 显式声明 pickfirstBalancer 实现了当前的 balancer.V2Balancer/base API，
+// 而不再是旧版 balancer.Balancer（Start/Up/Get/Notify 那一套）。
+var _ balancer.V2Balancer = (*pickfirstBalancer)(nil)
+
 // PickFirstBalancerName is the name of the pick_first balancer.
 const PickFirstBalancerName = "pick_first"
 
+// Default backoff parameters used when the service config does not supply a
+// PickFirstConfig, mirroring grpc-go's default connect backoff.
+const (
+	defaultBaseDelay  = 1 * time.Second
+	defaultMaxDelay   = 120 * time.Second
+	defaultMultiplier = 1.6
+	defaultJitter     = 0.2
+)
+
+// PickFirstConfig is the pick_first balancer's service config, parsed by
+// pickfirstBuilder.ParseConfig. It controls the exponential-backoff reconnect
+// loop that pickfirstBalancer drives in addition to the SubConn's own
+// internal reconnect timing.
+type PickFirstConfig struct {
+	// BaseDelay is the amount of time to backoff after the first failure.
+	BaseDelay time.Duration
+	// MaxDelay is the upper bound of the backoff delay.
+	MaxDelay time.Duration
+	// Multiplier is applied to the backoff delay after each failure.
+	Multiplier float64
+	// Jitter is the factor by which the backoff delay is randomized.
+	Jitter float64
+}
+
+// pickFirstConfigJSON 是 PickFirstConfig 对应的 service config JSON 结构，
+// 延迟字段采用 Go duration 字符串（如 "1s"），与 service config 的惯例保持一致。
+type pickFirstConfigJSON struct {
+	BaseDelay  string  `json:"baseDelay,omitempty"`
+	MaxDelay   string  `json:"maxDelay,omitempty"`
+	Multiplier float64 `json:"multiplier,omitempty"`
+	Jitter     float64 `json:"jitter,omitempty"`
+}
+
 func newPickfirstBuilder() balancer.Builder {
 	return &pickfirstBuilder{}
 }
@@ -44,14 +87,66 @@ func (*pickfirstBuilder) Name() string {
 	return PickFirstBalancerName
 }
 
+// ParseConfig 解析 service config 中 pick_first 的负载均衡配置，
+// 缺失的字段回退到 grpc-go 默认的连接退避参数。
+func (*pickfirstBuilder) ParseConfig(c json.RawMessage) (serviceconfig.LoadBalancingConfig, error) {
+	var raw pickFirstConfigJSON
+	if err := json.Unmarshal(c, &raw); err != nil {
+		return nil, fmt.Errorf("pickfirst: unable to unmarshal PickFirstConfig: %v", err)
+	}
+
+	cfg := &PickFirstConfig{
+		BaseDelay:  defaultBaseDelay,
+		MaxDelay:   defaultMaxDelay,
+		Multiplier: defaultMultiplier,
+		Jitter:     defaultJitter,
+	}
+	if raw.BaseDelay != "" {
+		d, err := time.ParseDuration(raw.BaseDelay)
+		if err != nil {
+			return nil, fmt.Errorf("pickfirst: invalid baseDelay %q: %v", raw.BaseDelay, err)
+		}
+		cfg.BaseDelay = d
+	}
+	if raw.MaxDelay != "" {
+		d, err := time.ParseDuration(raw.MaxDelay)
+		if err != nil {
+			return nil, fmt.Errorf("pickfirst: invalid maxDelay %q: %v", raw.MaxDelay, err)
+		}
+		cfg.MaxDelay = d
+	}
+	if raw.Multiplier > 0 {
+		cfg.Multiplier = raw.Multiplier
+	}
+	if raw.Jitter > 0 {
+		cfg.Jitter = raw.Jitter
+	}
+	return cfg, nil
+}
+
 type pickfirstBalancer struct {
+	cc balancer.ClientConn // 客户端连接
+
+	// mu 保护下面这些字段。gRPC 保证 UpdateClientConnState/UpdateSubConnState/
+	// Close/ExitIdle 不会并发调用，但 backoffTimer 到期时是在独立的计时器
+	// goroutine里运行，会在那条串行回调路径之外读写 sc，所以仍需要锁。
+	mu    sync.Mutex
 	state connectivity.State
-	cc    balancer.ClientConn // 客户端连接
-	sc    balancer.SubConn    // 子连接
+	sc    balancer.SubConn // 子连接
+
+	cfg   *PickFirstConfig   // 服务配置解析出的退避参数，nil 时使用默认值
+	addrs []resolver.Address // 上一次下发给 sc 的地址集合，用于判断地址是否发生了实质性变化
+
+	attempt      int         // 连续重连失败的次数，用于计算指数退避
+	backoffTimer *time.Timer // 驱动主动重连的定时器
 }
 
 func (b *pickfirstBalancer) ResolverError(err error) {
-	switch b.state {
+	b.mu.Lock()
+	state := b.state
+	b.mu.Unlock()
+
+	switch state {
 	case connectivity.TransientFailure, connectivity.Idle, connectivity.Connecting:
 		// Set a failing picker if we don't have a good picker.
 		b.cc.UpdateState(balancer.State{ConnectivityState: connectivity.TransientFailure,
@@ -71,13 +166,23 @@ func (b *pickfirstBalancer) UpdateClientConnState(cs balancer.ClientConnState) e
 		return balancer.ErrBadResolverState
 	}
 
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// 记录本次 service config 解析出的退避参数，供重连定时器使用
+	if cfg, ok := cs.BalancerConfig.(*PickFirstConfig); ok {
+		b.cfg = cfg
+	}
+
 	// 初始化子连接
 	if b.sc == nil {
 		grpclog.Infof("first time to NewSubConn: %+v", cs.ResolverState.Addresses)
 
 		var err error
-		// 基于地址列表初始化子连接，忽略子连接选项 -> ccBalancerWrapper
-		b.sc, err = b.cc.NewSubConn(cs.ResolverState.Addresses, balancer.NewSubConnOptions{})
+		// 基于地址列表初始化子连接，开启内置健康检查：
+		// 当 grpc.health.v1.Health 上报 NOT_SERVING 时，连接状态会被
+		// 下调为 TransientFailure，即便底层传输仍然是 Ready。
+		b.sc, err = b.cc.NewSubConn(cs.ResolverState.Addresses, balancer.NewSubConnOptions{HealthCheckEnabled: true})
 		if err != nil {
 			if grpclog.V(2) {
 				grpclog.Errorf("pickfirstBalancer: failed to NewSubConn: %v", err)
@@ -91,12 +196,39 @@ func (b *pickfirstBalancer) UpdateClientConnState(cs balancer.ClientConnState) e
 
 		// 更新连接状态：空闲状态
 		b.state = connectivity.Idle
+		b.addrs = cs.ResolverState.Addresses
 		b.cc.UpdateState(balancer.State{ConnectivityState: connectivity.Idle, Picker: &picker{result: balancer.PickResult{SubConn: b.sc}}})
 
 		// 创建连接
 		b.sc.Connect()
+	} else if addressSetsDiffer(b.addrs, cs.ResolverState.Addresses) {
+		// 地址集合发生了实质性变化（不只是顺序或属性调整），旧的 SubConn 已经
+		// 没有继续复用的意义：移除它并基于新地址列表重新创建，避免像
+		// UpdateAddresses 那样悄悄保留一个已经不在目标集合里的连接。
+		grpclog.Infof("address set changed, recreating SubConn: %+v", cs.ResolverState.Addresses)
+
+		newSC, err := b.cc.NewSubConn(cs.ResolverState.Addresses, balancer.NewSubConnOptions{HealthCheckEnabled: true})
+		if err != nil {
+			// 新建失败时旧的 SubConn 仍然有效（可能还是 Ready 的），不要用
+			// 失败 picker 覆盖掉它，否则会把一个正常工作的连接晾在一边。
+			if grpclog.V(2) {
+				grpclog.Errorf("pickfirstBalancer: failed to NewSubConn, keeping existing SubConn: %v", err)
+			}
+			return nil
+		}
+
+		oldSC := b.sc
+		b.sc = newSC
+		b.addrs = cs.ResolverState.Addresses
+		b.state = connectivity.Idle
+		b.attempt = 0
+		b.stopBackoffTimerLocked()
+		b.cc.UpdateState(balancer.State{ConnectivityState: connectivity.Idle, Picker: &picker{result: balancer.PickResult{SubConn: b.sc}}})
+		b.sc.Connect()
+		b.cc.RemoveSubConn(oldSC)
 	} else {
-		// 更新子连接地址列表
+		// 地址集合未发生实质性变化，仅做属性/顺序层面的更新
+		b.addrs = cs.ResolverState.Addresses
 		b.sc.UpdateAddresses(cs.ResolverState.Addresses)
 
 		// 创建连接
@@ -105,11 +237,38 @@ func (b *pickfirstBalancer) UpdateClientConnState(cs balancer.ClientConnState) e
 	return nil
 }
 
+// addressSetsDiffer 判断两个地址列表所代表的目标集合是否发生了实质性变化，
+// 只比较 Addr 和 ServerName（对应实际要连接的目标），忽略顺序及其他属性。
+func addressSetsDiffer(a, b []resolver.Address) bool {
+	if len(a) != len(b) {
+		return true
+	}
+	key := func(addr resolver.Address) string {
+		return addr.Addr + "|" + addr.ServerName
+	}
+	seen := make(map[string]int, len(a))
+	for _, addr := range a {
+		seen[key(addr)]++
+	}
+	for _, addr := range b {
+		k := key(addr)
+		if seen[k] == 0 {
+			return true
+		}
+		seen[k]--
+	}
+	return false
+}
+
 // UpdateSubConnState 子连接状态更新事件处理
 func (b *pickfirstBalancer) UpdateSubConnState(sc balancer.SubConn, s balancer.SubConnState) {
 	if grpclog.V(2) {
 		grpclog.Infof("pickfirstBalancer: UpdateSubConnState: %p, %v", sc, s)
 	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
 	if b.sc != sc {
 		if grpclog.V(2) {
 			grpclog.Infof("pickfirstBalancer: ignored state change because sc is not recognized")
@@ -121,21 +280,100 @@ func (b *pickfirstBalancer) UpdateSubConnState(sc balancer.SubConn, s balancer.S
 	b.state = s.ConnectivityState
 	if s.ConnectivityState == connectivity.Shutdown {
 		b.sc = nil
+		b.stopBackoffTimerLocked()
 		return
 	}
 
 	switch s.ConnectivityState {
-	case connectivity.Ready, connectivity.Idle:
-		// ccBalancerWrapper->UpdateState
+	case connectivity.Ready:
+		// 连接恢复健康，重置重连退避计数
+		b.attempt = 0
+		b.stopBackoffTimerLocked()
+		b.cc.UpdateState(balancer.State{ConnectivityState: s.ConnectivityState, Picker: &picker{result: balancer.PickResult{SubConn: sc}}})
+	case connectivity.Idle:
+		// Idle 通常是连接长时间空闲或被主动放弃，交给 ExitIdle/下一次 RPC
+		// 去触发重连即可；这里不重新安排退避定时器，否则会和 SubConn 自身
+		// 因空闲而断开的行为相互触发，形成永不停止的重连循环。
 		b.cc.UpdateState(balancer.State{ConnectivityState: s.ConnectivityState, Picker: &picker{result: balancer.PickResult{SubConn: sc}}})
 	case connectivity.Connecting:
 		b.cc.UpdateState(balancer.State{ConnectivityState: s.ConnectivityState, Picker: &picker{err: balancer.ErrNoSubConnAvailable}})
 	case connectivity.TransientFailure:
 		b.cc.UpdateState(balancer.State{ConnectivityState: s.ConnectivityState, Picker: &picker{err: s.ConnectionError}})
+		b.scheduleBackoffLocked()
 	}
 }
 
+// scheduleBackoffLocked 在 TransientFailure 时主动发起一次退避重连，不再
+// 完全依赖 SubConn 内部的重连时机，从而在 base/max/jitter 允许的范围内
+// 更积极地重新拨号。调用方必须持有 b.mu。
+//
+// 定时器到期后的回调运行在独立的 goroutine 里，不在 gRPC 保证串行的
+// balancer 回调路径上，因此它会重新获取 b.mu 之后才读写共享状态。
+func (b *pickfirstBalancer) scheduleBackoffLocked() {
+	b.stopBackoffTimerLocked()
+
+	delay := b.backoffDelay(b.attempt)
+	b.attempt++
+
+	sc := b.sc
+	b.backoffTimer = time.AfterFunc(delay, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if b.sc == sc {
+			sc.Connect()
+		}
+	})
+}
+
+// backoffDelay 计算第 attempt 次重连的指数退避延迟，并叠加随机抖动。
+func (b *pickfirstBalancer) backoffDelay(attempt int) time.Duration {
+	base, max, mult, jitter := defaultBaseDelay, defaultMaxDelay, defaultMultiplier, defaultJitter
+	if b.cfg != nil {
+		base, max, mult, jitter = b.cfg.BaseDelay, b.cfg.MaxDelay, b.cfg.Multiplier, b.cfg.Jitter
+	}
+
+	backoff, fMax := float64(base), float64(max)
+	for i := 0; i < attempt && backoff < fMax; i++ {
+		backoff *= mult
+	}
+	if backoff > fMax {
+		backoff = fMax
+	}
+	backoff *= 1 + jitter*(rand.Float64()*2-1)
+	if backoff < 0 {
+		backoff = 0
+	}
+	return time.Duration(backoff)
+}
+
+// stopBackoffTimerLocked 必须在持有 b.mu 的情况下调用。
+func (b *pickfirstBalancer) stopBackoffTimerLocked() {
+	if b.backoffTimer != nil {
+		b.backoffTimer.Stop()
+		b.backoffTimer = nil
+	}
+}
+
+// ExitIdle 在连接处于 Idle 状态时由 ClientConn 触发，主动发起一次连接尝试，
+// 而不是等待下一次 RPC 才惰性拨号。
+func (b *pickfirstBalancer) ExitIdle() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.sc != nil && b.state == connectivity.Idle {
+		b.sc.Connect()
+	}
+}
+
+// Close 关闭负载均衡器：停止退避定时器，并显式移除持有的 SubConn，
+// 避免在地址/配置频繁变化的场景下泄漏旧的 SubConn。
 func (b *pickfirstBalancer) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.stopBackoffTimerLocked()
+	if b.sc != nil {
+		b.cc.RemoveSubConn(b.sc)
+		b.sc = nil
+	}
 }
 
 type picker struct {
@@ -0,0 +1,152 @@
+/*
+ *
+ * Copyright 2017 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpc
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/grpclog"
+	"google.golang.org/grpc/resolver"
+)
+
+// PickFirstShuffleBalancerName is the name of the pick_first_shuffle balancer.
+//
+// Unlike pick_first, this variant never reuses the existing SubConn across a
+// resolver update: it always tears down the old one and dials a fresh SubConn
+// from the (optionally shuffled) address list. This is useful when callers
+// need the balancer to actually re-dial after a resolver update, e.g. after
+// the server list has been reshuffled behind a stable set of addresses.
+const PickFirstShuffleBalancerName = "pick_first_shuffle"
+
+// PickFirstShuffleOption configures a pickfirstShuffleBuilder returned by
+// NewPickFirstShuffleBuilder. Each built balancer owns its own *rand.Rand
+// seeded from these options, so shuffling never becomes a global, racy,
+// cross-client side effect.
+type PickFirstShuffleOption func(*pickfirstShuffleBuilder)
+
+// WithShuffleSeed seeds the per-balancer random source deterministically,
+// e.g. for reproducible tests.
+func WithShuffleSeed(seed int64) PickFirstShuffleOption {
+	return func(b *pickfirstShuffleBuilder) {
+		b.seed = seed
+		b.hasSeed = true
+	}
+}
+
+// WithShuffleDisabled rebuilds the SubConn on every address update without
+// shuffling, preserving the resolver's original ordering.
+func WithShuffleDisabled() PickFirstShuffleOption {
+	return func(b *pickfirstShuffleBuilder) {
+		b.shuffle = false
+	}
+}
+
+// NewPickFirstShuffleBuilder 创建一个 pick_first_shuffle 的 builder 实例，
+// 用于自定义注册（例如 balancer.Register(grpc.NewPickFirstShuffleBuilder(...))）。
+func NewPickFirstShuffleBuilder(opts ...PickFirstShuffleOption) balancer.Builder {
+	b := &pickfirstShuffleBuilder{shuffle: true}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+type pickfirstShuffleBuilder struct {
+	shuffle bool
+	seed    int64
+	hasSeed bool
+}
+
+func (b *pickfirstShuffleBuilder) Build(cc balancer.ClientConn, opt balancer.BuildOptions) balancer.Balancer {
+	seed := b.seed
+	if !b.hasSeed {
+		// 每个 balancer 实例使用互不相同的默认种子，避免跨 client 共享
+		// 同一个随机序列。
+		seed = time.Now().UnixNano()
+	}
+	return &pickfirstShuffleBalancer{
+		pickfirstBalancer: pickfirstBalancer{cc: cc},
+		shuffle:           b.shuffle,
+		rng:               rand.New(rand.NewSource(seed)),
+	}
+}
+
+func (*pickfirstShuffleBuilder) Name() string {
+	return PickFirstShuffleBalancerName
+}
+
+// pickfirstShuffleBalancer 复用 pickfirstBalancer 的状态处理逻辑，
+// 只重写 UpdateClientConnState 使每次地址更新都重建 SubConn。
+// rng 只在这个 balancer 自己的串行回调路径中使用，不与其他 balancer 共享。
+type pickfirstShuffleBalancer struct {
+	pickfirstBalancer
+	shuffle bool
+	rng     *rand.Rand
+}
+
+// UpdateClientConnState 每次收到新地址列表都移除旧的 SubConn 并新建一个，
+// 而不是像 pick_first 那样在旧地址仍然存在时保留旧连接。
+func (b *pickfirstShuffleBalancer) UpdateClientConnState(cs balancer.ClientConnState) error {
+	if len(cs.ResolverState.Addresses) == 0 {
+		b.ResolverError(errors.New("produced zero addresses"))
+		return balancer.ErrBadResolverState
+	}
+
+	addrs := make([]resolver.Address, len(cs.ResolverState.Addresses))
+	copy(addrs, cs.ResolverState.Addresses)
+	if b.shuffle {
+		b.rng.Shuffle(len(addrs), func(i, j int) { addrs[i], addrs[j] = addrs[j], addrs[i] })
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// 总是移除旧的子连接，保证每次更新都重新拨号
+	if b.sc != nil {
+		b.cc.RemoveSubConn(b.sc)
+		b.sc = nil
+	}
+
+	sc, err := b.cc.NewSubConn(addrs, balancer.NewSubConnOptions{})
+	if err != nil {
+		if grpclog.V(2) {
+			grpclog.Errorf("pickfirstShuffleBalancer: failed to NewSubConn: %v", err)
+		}
+		b.state = connectivity.TransientFailure
+		b.cc.UpdateState(balancer.State{ConnectivityState: connectivity.TransientFailure,
+			Picker: &picker{err: fmt.Errorf("error creating connection: %v", err)},
+		})
+		return balancer.ErrBadResolverState
+	}
+
+	b.sc = sc
+	b.state = connectivity.Idle
+	b.cc.UpdateState(balancer.State{ConnectivityState: connectivity.Idle, Picker: &picker{result: balancer.PickResult{SubConn: b.sc}}})
+	b.sc.Connect()
+	return nil
+}
+
+func init() {
+	balancer.Register(NewPickFirstShuffleBuilder())
+}
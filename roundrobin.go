@@ -0,0 +1,196 @@
+/*
+ *
+ * Copyright 2017 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpc
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/grpclog"
+	"google.golang.org/grpc/resolver"
+)
+
+// RoundRobinBalancerName is the name of the round_robin balancer.
+const RoundRobinBalancerName = "round_robin"
+
+func newRoundRobinBuilder() balancer.Builder {
+	return &roundrobinBuilder{}
+}
+
+type roundrobinBuilder struct{}
+
+func (*roundrobinBuilder) Build(cc balancer.ClientConn, opt balancer.BuildOptions) balancer.Balancer {
+	return &roundrobinBalancer{cc: cc}
+}
+
+func (*roundrobinBuilder) Name() string {
+	return RoundRobinBalancerName
+}
+
+// addrKey 以 Addr+ServerName 作为地址的去重/对比键，与 pickfirst 中
+// addressSetsDiffer 的约定保持一致。
+func addrKey(addr resolver.Address) string {
+	return addr.Addr + "|" + addr.ServerName
+}
+
+type roundrobinBalancer struct {
+	cc    balancer.ClientConn // 客户端连接
+	state connectivity.State  // 上一次下发给 ClientConn 的聚合状态
+
+	// scStates 记录每个子连接当前的连接状态
+	scStates map[balancer.SubConn]connectivity.State
+	// scByAddr 记录每个地址当前对应的子连接，用于按地址集合做增量对比
+	scByAddr map[string]balancer.SubConn
+}
+
+// ResolverError 地址解析失败时，仅在还没有可用 picker 的情况下下发失败 picker
+func (b *roundrobinBalancer) ResolverError(err error) {
+	if b.state != connectivity.Ready {
+		b.cc.UpdateState(balancer.State{
+			ConnectivityState: connectivity.TransientFailure,
+			Picker:            &picker{err: fmt.Errorf("name resolver error: %v", err)},
+		})
+	}
+	if grpclog.V(2) {
+		grpclog.Infof("roundrobinBalancer: ResolverError called with error %v", err)
+	}
+}
+
+// UpdateClientConnState 根据最新地址列表同步子连接集合：
+// 新增的地址创建子连接，不再出现的地址对应的子连接被移除，
+// 避免每次 resolver 更新都重复创建、连接数无限增长。
+func (b *roundrobinBalancer) UpdateClientConnState(cs balancer.ClientConnState) error {
+	if len(cs.ResolverState.Addresses) == 0 {
+		b.ResolverError(fmt.Errorf("produced zero addresses"))
+		return balancer.ErrBadResolverState
+	}
+
+	if b.scStates == nil {
+		b.scStates = make(map[balancer.SubConn]connectivity.State)
+		b.scByAddr = make(map[string]balancer.SubConn)
+	}
+
+	changed := false
+
+	keep := make(map[string]bool, len(cs.ResolverState.Addresses))
+	for _, addr := range cs.ResolverState.Addresses {
+		key := addrKey(addr)
+		keep[key] = true
+		if _, ok := b.scByAddr[key]; ok {
+			// 地址已有对应的子连接，保持不变
+			continue
+		}
+
+		sc, err := b.cc.NewSubConn([]resolver.Address{addr}, balancer.NewSubConnOptions{})
+		if err != nil {
+			if grpclog.V(2) {
+				grpclog.Errorf("roundrobinBalancer: failed to NewSubConn: %v", err)
+			}
+			continue
+		}
+		b.scStates[sc] = connectivity.Idle
+		b.scByAddr[key] = sc
+		sc.Connect()
+		changed = true
+	}
+
+	// 移除新地址列表中不再出现的子连接
+	for key, sc := range b.scByAddr {
+		if keep[key] {
+			continue
+		}
+		b.cc.RemoveSubConn(sc)
+		delete(b.scByAddr, key)
+		delete(b.scStates, sc)
+		changed = true
+	}
+
+	if changed {
+		// 子连接集合发生了变化：被移除的 SubConn 不能继续留在上一次生成的
+		// picker 快照里，否则 Pick 还会把请求路由到正在关闭的连接上。
+		b.regeneratePicker()
+	}
+	return nil
+}
+
+// UpdateSubConnState 子连接状态更新时，重新汇总 Ready 的子连接并刷新 picker
+func (b *roundrobinBalancer) UpdateSubConnState(sc balancer.SubConn, s balancer.SubConnState) {
+	old, ok := b.scStates[sc]
+	if !ok {
+		return
+	}
+	if s.ConnectivityState == connectivity.Shutdown {
+		delete(b.scStates, sc)
+		// 刷新 picker：否则关闭的子连接会继续留在上一次生成的快照里，被选中时
+		// RPC 会打到一个正在关闭的连接上。经 UpdateClientConnState 移除的子连接
+		// 在那里已从 scStates 删除并刷新过 picker，它们的 Shutdown 在上面被忽略。
+		b.regeneratePicker()
+		return
+	}
+	if old == s.ConnectivityState {
+		return
+	}
+	b.scStates[sc] = s.ConnectivityState
+	b.regeneratePicker()
+}
+
+// regeneratePicker 收集所有 Ready 状态的子连接，生成新的轮询 picker
+func (b *roundrobinBalancer) regeneratePicker() {
+	var ready []balancer.SubConn
+	for sc, st := range b.scStates {
+		if st == connectivity.Ready {
+			ready = append(ready, sc)
+		}
+	}
+	if len(ready) == 0 {
+		b.state = connectivity.TransientFailure
+		b.cc.UpdateState(balancer.State{
+			ConnectivityState: connectivity.TransientFailure,
+			Picker:            &picker{err: balancer.ErrNoSubConnAvailable},
+		})
+		return
+	}
+	b.state = connectivity.Ready
+	b.cc.UpdateState(balancer.State{
+		ConnectivityState: connectivity.Ready,
+		Picker:            &rrPicker{subConns: ready},
+	})
+}
+
+func (b *roundrobinBalancer) Close() {
+}
+
+// rrPicker 按生成 picker 时 Ready 子连接快照的顺序做轮询选择；
+// 该快照由遍历 map 得到，子连接之间的先后顺序不固定
+type rrPicker struct {
+	subConns []balancer.SubConn
+	next     uint32 // 原子自增的轮询游标
+}
+
+func (p *rrPicker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	idx := atomic.AddUint32(&p.next, 1)
+	sc := p.subConns[idx%uint32(len(p.subConns))]
+	return balancer.PickResult{SubConn: sc}, nil
+}
+
+func init() {
+	balancer.Register(newRoundRobinBuilder())
+}
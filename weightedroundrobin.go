@@ -0,0 +1,242 @@
+/*
+ *
+ * Copyright 2017 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpc
+
+import (
+	"sync"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/grpclog"
+	"google.golang.org/grpc/resolver"
+)
+
+// WeightedRoundRobinBalancerName is the name of the weighted_round_robin balancer.
+const WeightedRoundRobinBalancerName = "weighted_round_robin"
+
+// weightAttributeKey 用于从 resolver.Address.Attributes 中读取权重
+type weightAttributeKey struct{}
+
+// WeightedAddress 返回一个携带权重属性的地址，供 resolver 在地址列表中标注权重
+func WeightedAddress(addr resolver.Address, weight int) resolver.Address {
+	addr.Attributes = addr.Attributes.WithValue(weightAttributeKey{}, weight)
+	return addr
+}
+
+// addrWeight 从地址的 Attributes 中解析权重，缺省为 1
+func addrWeight(addr resolver.Address) int {
+	if addr.Attributes == nil {
+		return 1
+	}
+	w, ok := addr.Attributes.Value(weightAttributeKey{}).(int)
+	if !ok || w <= 0 {
+		return 1
+	}
+	return w
+}
+
+func newWeightedRoundRobinBuilder() balancer.Builder {
+	return &weightedRoundRobinBuilder{}
+}
+
+type weightedRoundRobinBuilder struct{}
+
+func (*weightedRoundRobinBuilder) Build(cc balancer.ClientConn, opt balancer.BuildOptions) balancer.Balancer {
+	return &weightedRoundRobinBalancer{cc: cc}
+}
+
+func (*weightedRoundRobinBuilder) Name() string {
+	return WeightedRoundRobinBalancerName
+}
+
+// wrrEntry 是balancer 自己持有的子连接簿记：权重与连接状态。
+// 注意它不持有 SWRR 算法的 current 值——那部分状态只属于某一个 picker
+// 实例，绝不会被 balancer 或其他 picker 并发读写。
+type wrrEntry struct {
+	weight int
+	state  connectivity.State
+}
+
+type weightedRoundRobinBalancer struct {
+	cc balancer.ClientConn // 客户端连接
+
+	mu       sync.Mutex
+	entries  map[balancer.SubConn]*wrrEntry
+	scByAddr map[string]balancer.SubConn
+	addrBySC map[balancer.SubConn]string // scByAddr 的反向索引，便于按 SubConn 清理
+}
+
+func (b *weightedRoundRobinBalancer) ResolverError(err error) {
+	if grpclog.V(2) {
+		grpclog.Infof("weightedRoundRobinBalancer: ResolverError called with error %v", err)
+	}
+}
+
+// UpdateClientConnState 根据最新地址列表同步子连接集合，并记下每个地址
+// 解析到的权重。新增的地址创建子连接，不再出现的地址对应的子连接被移除，
+// 避免每次 resolver 更新都重复创建、连接数无限增长。
+func (b *weightedRoundRobinBalancer) UpdateClientConnState(cs balancer.ClientConnState) error {
+	if len(cs.ResolverState.Addresses) == 0 {
+		return balancer.ErrBadResolverState
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.entries == nil {
+		b.entries = make(map[balancer.SubConn]*wrrEntry)
+		b.scByAddr = make(map[string]balancer.SubConn)
+		b.addrBySC = make(map[balancer.SubConn]string)
+	}
+
+	changed := false
+
+	keep := make(map[string]bool, len(cs.ResolverState.Addresses))
+	for _, addr := range cs.ResolverState.Addresses {
+		key := addrKey(addr)
+		keep[key] = true
+		if sc, ok := b.scByAddr[key]; ok {
+			// 地址已有对应的子连接，只需要刷新权重（resolver 下发的权重属性可能已更新）；
+			// 权重变化时需要重新生成 picker，否则现有 picker 快照仍按旧权重选择
+			if w := addrWeight(addr); b.entries[sc].weight != w {
+				b.entries[sc].weight = w
+				changed = true
+			}
+			continue
+		}
+
+		sc, err := b.cc.NewSubConn([]resolver.Address{addr}, balancer.NewSubConnOptions{})
+		if err != nil {
+			if grpclog.V(2) {
+				grpclog.Errorf("weightedRoundRobinBalancer: failed to NewSubConn: %v", err)
+			}
+			continue
+		}
+		b.entries[sc] = &wrrEntry{weight: addrWeight(addr), state: connectivity.Idle}
+		b.scByAddr[key] = sc
+		b.addrBySC[sc] = key
+		sc.Connect()
+		changed = true
+	}
+
+	// 移除新地址列表中不再出现的子连接
+	for key, sc := range b.scByAddr {
+		if keep[key] {
+			continue
+		}
+		b.cc.RemoveSubConn(sc)
+		delete(b.scByAddr, key)
+		delete(b.addrBySC, sc)
+		delete(b.entries, sc)
+		changed = true
+	}
+
+	if changed {
+		// 子连接集合发生了变化：被移除的 SubConn 不能继续留在上一次生成的
+		// picker 快照里，否则 SWRR 还会继续选中一个正在关闭的连接。
+		b.regeneratePickerLocked()
+	}
+	return nil
+}
+
+func (b *weightedRoundRobinBalancer) UpdateSubConnState(sc balancer.SubConn, s balancer.SubConnState) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	e, ok := b.entries[sc]
+	if !ok {
+		return
+	}
+	if s.ConnectivityState == connectivity.Shutdown {
+		delete(b.entries, sc)
+		if key, ok := b.addrBySC[sc]; ok {
+			delete(b.addrBySC, sc)
+			delete(b.scByAddr, key)
+		}
+		// 刷新 picker：否则关闭的子连接会继续留在上一次生成的快照里，被选中时
+		// RPC 会打到一个正在关闭的连接上。经 UpdateClientConnState 移除的子连接
+		// 在那里已从 entries 删除并刷新过 picker，它们的 Shutdown 在上面被忽略。
+		b.regeneratePickerLocked()
+		return
+	}
+	if e.state == s.ConnectivityState {
+		return
+	}
+	e.state = s.ConnectivityState
+	b.regeneratePickerLocked()
+}
+
+func (b *weightedRoundRobinBalancer) regeneratePickerLocked() {
+	var ready []*pickerEntry
+	for sc, e := range b.entries {
+		if e.state == connectivity.Ready {
+			ready = append(ready, &pickerEntry{sc: sc, weight: e.weight})
+		}
+	}
+	if len(ready) == 0 {
+		b.cc.UpdateState(balancer.State{
+			ConnectivityState: connectivity.TransientFailure,
+			Picker:            &picker{err: balancer.ErrNoSubConnAvailable},
+		})
+		return
+	}
+	b.cc.UpdateState(balancer.State{
+		ConnectivityState: connectivity.Ready,
+		Picker:            &wrrPicker{entries: ready},
+	})
+}
+
+func (b *weightedRoundRobinBalancer) Close() {
+}
+
+// pickerEntry 是某一个 wrrPicker 私有的 SWRR 状态：sc/weight 创建后不变，
+// current 只会被拥有它的那个 picker 在持锁状态下读写。
+type pickerEntry struct {
+	sc      balancer.SubConn
+	weight  int
+	current int
+}
+
+// wrrPicker 实现平滑加权轮询（smooth weighted round-robin）：
+// 每次 Pick 时，所有条目的 current 加上自身权重，选出 current 最大者并扣减权重之和。
+// entries 是生成该 picker 时的快照，不与 balancer 或其他 picker 共享。
+type wrrPicker struct {
+	mu      sync.Mutex
+	entries []*pickerEntry
+}
+
+func (p *wrrPicker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	total := 0
+	var best *pickerEntry
+	for _, e := range p.entries {
+		e.current += e.weight
+		total += e.weight
+		if best == nil || e.current > best.current {
+			best = e
+		}
+	}
+	best.current -= total
+	return balancer.PickResult{SubConn: best.sc}, nil
+}
+
+func init() {
+	balancer.Register(newWeightedRoundRobinBuilder())
+}